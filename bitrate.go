@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// デフォルトの音声ビットレート。convertOneの通常経路の "-b:a 128k" と揃えている。
+const defaultAudioBitrateBps = 128_000
+
+// runFfmpeg はffmpegを起動し、-progress pipe:1 の出力をreporterに流しながら完了を待ちます。
+func runFfmpeg(ffmpegPath string, args []string, label string, reporter *progressReporter) error {
+	cmd := exec.Command(ffmpegPath, args...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("標準出力の取得に失敗: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("標準エラー出力の取得に失敗: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("ffmpeg起動エラー: %w", err)
+	}
+
+	var errBuf bytes.Buffer
+	var pipeWG sync.WaitGroup
+	pipeWG.Add(2)
+	go func() {
+		defer pipeWG.Done()
+		_ = parseProgressStream(stdout, func(p ffmpegProgress) {
+			reporter.onUpdate(label, p)
+		})
+	}()
+	go func() {
+		defer pipeWG.Done()
+		_, _ = io.Copy(&errBuf, stderr)
+	}()
+	pipeWG.Wait()
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg実行エラー: %v\n%s", err, errBuf.String())
+	}
+	return nil
+}
+
+// convertTwoPass はtarget-bitrate/target-sizeが指定された場合の2passエンコードを行います。
+// 1pass目はnullミキサーに統計情報だけを書き出し、2pass目でその統計を使って実ファイルを出力します。
+// 両passともconvertOne呼び出し元の並列セマフォ内で逐次実行されるため、合計ワーカー数は変わりません。
+func convertTwoPass(ffmpegPath, inPath, outPath, vf, label string, encoder Encoder, extra map[string]string, total time.Duration, reporter *progressReporter) error {
+	if tpc, ok := encoder.(interface{ SupportsTwoPass() bool }); ok && !tpc.SupportsTwoPass() {
+		return fmt.Errorf("エンコーダ %q は --target-bitrate/--target-size の2passエンコードに対応していません"+
+			" (ffmpeg汎用のstatsファイル方式の2passを実装しないハードウェアエンコーダのため)。"+
+			" --crf相当の品質指定を使うか、--encoder でソフトウェアエンコーダ(x264/x265/av1)を選んでください", encoder.Name())
+	}
+
+	audioBitrateBps := int64(defaultAudioBitrateBps)
+	if mute {
+		audioBitrateBps = 0
+	}
+
+	videoBps, err := targetVideoBitrate(targetBitrate, targetSize, total, audioBitrateBps)
+	if err != nil {
+		return err
+	}
+
+	passLogPrefix := filepath.Join(os.TempDir(), fmt.Sprintf("ffmpeg2pass-%d-%d", os.Getpid(), time.Now().UnixNano()))
+	defer cleanupPassLogs(passLogPrefix)
+
+	buildBaseArgs := func() []string {
+		args := []string{"-y", "-i", inPath}
+		args = append(args, encoder.BuildArgs(EncoderOptions{CRF: crf, Preset: preset, Extra: extra, BitrateBps: &videoBps})...)
+		args = append(args, "-vf", vf)
+		if fps > 0 {
+			args = append(args, "-r", fmt.Sprintf("%d", fps))
+		}
+		return args
+	}
+
+	log.Printf("▶ 1pass目 (解析): %s (目標映像ビットレート %d bps)", inPath, videoBps)
+	pass1Args := buildBaseArgs()
+	pass1Args = append(pass1Args,
+		"-pass", "1", "-passlogfile", passLogPrefix,
+		"-an", "-progress", "pipe:1", "-nostats",
+		"-f", "null", os.DevNull,
+	)
+	if err := runFfmpeg(ffmpegPath, pass1Args, label, reporter); err != nil {
+		return fmt.Errorf("1pass目に失敗: %w", err)
+	}
+
+	log.Printf("▶ 2pass目 (出力): %s -> %s", inPath, outPath)
+	pass2Args := buildBaseArgs()
+	pass2Args = append(pass2Args, "-pass", "2", "-passlogfile", passLogPrefix, "-movflags", "+faststart")
+	if mute {
+		pass2Args = append(pass2Args, "-an")
+	} else {
+		pass2Args = append(pass2Args, "-acodec", "aac", "-b:a", "128k", "-ac", "2")
+	}
+	pass2Args = append(pass2Args, "-progress", "pipe:1", "-nostats", outPath)
+	if err := runFfmpeg(ffmpegPath, pass2Args, label, reporter); err != nil {
+		return fmt.Errorf("2pass目に失敗: %w", err)
+	}
+	return nil
+}
+
+// cleanupPassLogs はffmpegが2pass解析時に書き出す ffmpeg2pass-*.log* 系の一時ファイルを削除します。
+func cleanupPassLogs(prefix string) {
+	matches, err := filepath.Glob(prefix + "*.log*")
+	if err != nil {
+		return
+	}
+	for _, m := range matches {
+		if err := os.Remove(m); err != nil {
+			log.Printf("⚠ 2passログの削除に失敗: %s -> %v", m, err)
+		}
+	}
+}
+
+// targetVideoBitrate はtargetBitrate/targetSizeの指定から2pass目に渡す映像ビットレート(bps)を求めます。
+// targetBitrateが指定されていればそれをそのまま使い、未指定でtargetSizeが指定されている場合は
+// 動画長(total)からビットレートを逆算し、音声分を差し引きます。
+func targetVideoBitrate(targetBitrate, targetSize string, total time.Duration, audioBitrateBps int64) (int64, error) {
+	if targetBitrate != "" {
+		return parseBitrate(targetBitrate)
+	}
+
+	sizeBytes, err := parseByteSize(targetSize)
+	if err != nil {
+		return 0, err
+	}
+	if total <= 0 {
+		return 0, fmt.Errorf("動画長が取得できないため --target-size からビットレートを算出できません")
+	}
+
+	totalBps := float64(sizeBytes*8) / total.Seconds()
+	videoBps := int64(totalBps) - audioBitrateBps
+	if videoBps <= 0 {
+		return 0, fmt.Errorf("目標サイズが小さすぎます (音声ビットレートを差し引くと映像ビットレートが0以下になります)")
+	}
+	return videoBps, nil
+}
+
+// parseBitrate は "4M", "128k" のようなビットレート表記をbpsに変換します。
+func parseBitrate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("ビットレートが指定されていません")
+	}
+
+	multiplier := int64(1)
+	numPart := s
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		multiplier = 1_000
+		numPart = s[:len(s)-1]
+	case "M":
+		multiplier = 1_000_000
+		numPart = s[:len(s)-1]
+	case "G":
+		multiplier = 1_000_000_000
+		numPart = s[:len(s)-1]
+	}
+
+	val, err := strconv.ParseFloat(numPart, 64)
+	if err != nil {
+		return 0, fmt.Errorf("不正なビットレート指定です: %q", s)
+	}
+	return int64(val * float64(multiplier)), nil
+}
+
+// parseByteSize は "500MB", "1.5GB" のようなサイズ表記をバイト数に変換します。
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("サイズが指定されていません")
+	}
+	upper := strings.ToUpper(s)
+
+	multiplier := int64(1)
+	numPart := s
+	switch {
+	case strings.HasSuffix(upper, "GB"):
+		multiplier = 1_000_000_000
+		numPart = s[:len(s)-2]
+	case strings.HasSuffix(upper, "MB"):
+		multiplier = 1_000_000
+		numPart = s[:len(s)-2]
+	case strings.HasSuffix(upper, "KB"):
+		multiplier = 1_000
+		numPart = s[:len(s)-2]
+	case strings.HasSuffix(upper, "B"):
+		numPart = s[:len(s)-1]
+	}
+
+	val, err := strconv.ParseFloat(numPart, 64)
+	if err != nil {
+		return 0, fmt.Errorf("不正なサイズ指定です: %q", s)
+	}
+	return int64(val * float64(multiplier)), nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestConvertTwoPassRejectsUnsupportedEncoders は、ffmpeg汎用のstatsファイル方式2passに
+// 対応しないハードウェアエンコーダ(nvenc/videotoolbox)が指定された場合に、
+// 実際にffmpegを起動する前にエラーで弾かれることを確認します。
+func TestConvertTwoPassRejectsUnsupportedEncoders(t *testing.T) {
+	cases := []struct {
+		name    string
+		encoder Encoder
+	}{
+		{"nvenc", newNvencEncoder()},
+		{"videotoolbox", newVideotoolboxEncoder()},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := convertTwoPass("ffmpeg", "in.mov", "out.mp4", "scale=1920:1080", "in.mov", c.encoder, nil, 10*time.Second, nil)
+			if err == nil {
+				t.Fatalf("convertTwoPass with %s encoder: expected error, got nil", c.name)
+			}
+		})
+	}
+}
+
+func TestParseBitrate(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"megabit suffix", "4M", 4_000_000, false},
+		{"kilobit suffix lowercase", "128k", 128_000, false},
+		{"gigabit suffix", "1G", 1_000_000_000, false},
+		{"no suffix", "500", 500, false},
+		{"fractional megabit", "2.5M", 2_500_000, false},
+		{"empty", "", 0, true},
+		{"not a number", "abc", 0, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseBitrate(c.in)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("parseBitrate(%q) = %d, want error", c.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseBitrate(%q) unexpected error: %v", c.in, err)
+			}
+			if got != c.want {
+				t.Errorf("parseBitrate(%q) = %d, want %d", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"megabytes", "500MB", 500_000_000, false},
+		{"gigabytes fractional", "1.5GB", 1_500_000_000, false},
+		{"kilobytes", "10KB", 10_000, false},
+		{"bytes", "100B", 100, false},
+		{"no suffix treated as bytes", "100", 100, false},
+		{"empty", "", 0, true},
+		{"not a number", "abc", 0, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseByteSize(c.in)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("parseByteSize(%q) = %d, want error", c.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseByteSize(%q) unexpected error: %v", c.in, err)
+			}
+			if got != c.want {
+				t.Errorf("parseByteSize(%q) = %d, want %d", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestTargetVideoBitrate(t *testing.T) {
+	t.Run("target-bitrate takes priority over target-size", func(t *testing.T) {
+		got, err := targetVideoBitrate("4M", "500MB", 10*time.Second, 128_000)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != 4_000_000 {
+			t.Errorf("got %d, want 4000000", got)
+		}
+	})
+
+	t.Run("target-size derives bitrate from duration minus audio", func(t *testing.T) {
+		// 100MB over 100s = 8,000,000 bps total; minus 128,000 bps audio.
+		got, err := targetVideoBitrate("", "100MB", 100*time.Second, 128_000)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := int64(8_000_000 - 128_000)
+		if got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("target-size without a known duration is an error", func(t *testing.T) {
+		if _, err := targetVideoBitrate("", "100MB", 0, 128_000); err == nil {
+			t.Fatal("expected error when duration is unknown")
+		}
+	})
+
+	t.Run("target-size too small to cover audio bitrate is an error", func(t *testing.T) {
+		if _, err := targetVideoBitrate("", "1KB", 100*time.Second, 128_000); err == nil {
+			t.Fatal("expected error when the resulting video bitrate would be <= 0")
+		}
+	})
+
+	t.Run("invalid target-bitrate surfaces a parse error", func(t *testing.T) {
+		if _, err := targetVideoBitrate("not-a-bitrate", "", time.Second, 128_000); err == nil {
+			t.Fatal("expected parse error")
+		}
+	})
+}
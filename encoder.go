@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// EncoderOptions はCRF/プリセットや --encoder-opt で渡されたエンコーダ固有パラメータです。
+type EncoderOptions struct {
+	CRF    int
+	Preset string
+	Extra  map[string]string // --encoder-opt key=value の内容
+	// BitrateBps が指定されている場合、各エンコーダのBuildArgsは-crf/-cq/-q:vのような
+	// 品質ベースのレート制御ではなく、このビットレートでのABR/2passレート制御に切り替えます
+	// (convertTwoPassの2passエンコードで使用)。
+	BitrateBps *int64
+}
+
+// Encoder はエンコーダごとのffmpeg引数組み立てを抽象化します。
+// --encoder フラグで選択されたものが convertOne から呼び出されます。
+type Encoder interface {
+	// Name はCLIの --encoder で指定する識別子です。
+	Name() string
+	// BuildArgs は -i/-vf より後ろ、-movflags より前に挿入する
+	// 映像コーデック関連のffmpeg引数を組み立てます。
+	BuildArgs(opts EncoderOptions) []string
+	// Validate はffmpeg上でこのエンコーダが利用可能かどうかを
+	// `ffmpeg -h encoder=<name>` の終了コードで検証します。
+	Validate(ffmpegPath string) error
+}
+
+// ffmpegCodecName を実装するエンコーダは ffmpeg -encoders の出力に現れる
+// エンコーダ名(libx264など)を返します。Validateのデフォルト実装で使用します。
+type ffmpegCodecEncoder struct {
+	codec string
+}
+
+// ffmpegName はffmpeg上のエンコーダ名(libx264など)を返します。
+func (e ffmpegCodecEncoder) ffmpegName() string { return e.codec }
+
+// SupportsTwoPass はこのエンコーダが-passによるstatsファイル方式の2passエンコード
+// (convertTwoPass)に対応しているかを返します。デフォルトは対応ありで、
+// 汎用の2passを実装しないハードウェアエンコーダがfalseで上書きします。
+func (e ffmpegCodecEncoder) SupportsTwoPass() bool { return true }
+
+func (e ffmpegCodecEncoder) Validate(ffmpegPath string) error {
+	cmd := exec.Command(ffmpegPath, "-hide_banner", "-h", "encoder="+e.codec)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("エンコーダ %q はこの ffmpeg では利用できません: %v\n%s", e.codec, err, stderr.String())
+	}
+	return nil
+}
+
+// x264Encoder はソフトウェアH.264 (libx264) です。既定のエンコーダ。
+type x264Encoder struct{ ffmpegCodecEncoder }
+
+func newX264Encoder() *x264Encoder { return &x264Encoder{ffmpegCodecEncoder{"libx264"}} }
+
+func (e *x264Encoder) Name() string { return "x264" }
+
+func (e *x264Encoder) BuildArgs(opts EncoderOptions) []string {
+	args := []string{"-vcodec", "libx264", "-preset", opts.Preset}
+	args = append(args, rateControlArgs(opts)...)
+	args = append(args, "-pix_fmt", "yuv420p")
+	if tune, ok := opts.Extra["tune"]; ok {
+		args = append(args, "-tune", tune)
+	}
+	return append(args, extraArgs(opts, "tune")...)
+}
+
+// x265Encoder はソフトウェアH.265 (libx265) です。
+type x265Encoder struct{ ffmpegCodecEncoder }
+
+func newX265Encoder() *x265Encoder { return &x265Encoder{ffmpegCodecEncoder{"libx265"}} }
+
+func (e *x265Encoder) Name() string { return "x265" }
+
+func (e *x265Encoder) BuildArgs(opts EncoderOptions) []string {
+	args := []string{"-vcodec", "libx265", "-preset", opts.Preset}
+	args = append(args, rateControlArgs(opts)...)
+	args = append(args, "-pix_fmt", "yuv420p")
+	if tag, ok := opts.Extra["tag"]; ok {
+		args = append(args, "-tag:v", tag)
+	} else {
+		// QuickTime/Apple系プレイヤーでの再生互換性のためのデフォルトタグ
+		args = append(args, "-tag:v", "hvc1")
+	}
+	return append(args, extraArgs(opts, "tag")...)
+}
+
+// nvencEncoder はNVIDIA GPUによるハードウェアH.264エンコード (h264_nvenc) です。
+// CRFの概念がないため品質指定は -cq にマッピングします。
+type nvencEncoder struct{ ffmpegCodecEncoder }
+
+func newNvencEncoder() *nvencEncoder { return &nvencEncoder{ffmpegCodecEncoder{"h264_nvenc"}} }
+
+func (e *nvencEncoder) Name() string { return "nvenc" }
+
+// SupportsTwoPass: NVENCは-passで吐き出すffmpeg汎用のstatsファイル方式の2passに対応していない
+// (独自のルックアヘッド/VBVでレート制御するため)、falseで上書きする。
+func (e *nvencEncoder) SupportsTwoPass() bool { return false }
+
+func (e *nvencEncoder) BuildArgs(opts EncoderOptions) []string {
+	preset := opts.Preset
+	if preset == "" || preset == "faster" {
+		preset = "p4" // NVENCのプリセット名はx264と体系が異なる
+	}
+	args := []string{
+		"-vcodec", "h264_nvenc",
+		"-preset", preset,
+		"-rc", "vbr",
+		"-cq", fmt.Sprintf("%d", opts.CRF),
+	}
+	return append(args, extraArgs(opts)...)
+}
+
+// videotoolboxEncoder はmacOS VideoToolboxによるハードウェアH.265エンコード
+// (hevc_videotoolbox) です。CRFではなく -q:v で品質を指定します。
+type videotoolboxEncoder struct{ ffmpegCodecEncoder }
+
+func newVideotoolboxEncoder() *videotoolboxEncoder {
+	return &videotoolboxEncoder{ffmpegCodecEncoder{"hevc_videotoolbox"}}
+}
+
+func (e *videotoolboxEncoder) Name() string { return "videotoolbox" }
+
+// SupportsTwoPass: VideoToolboxもffmpeg汎用のstatsファイル方式の2passに対応していない。
+func (e *videotoolboxEncoder) SupportsTwoPass() bool { return false }
+
+func (e *videotoolboxEncoder) BuildArgs(opts EncoderOptions) []string {
+	args := []string{
+		"-vcodec", "hevc_videotoolbox",
+		"-q:v", fmt.Sprintf("%d", opts.CRF),
+		"-tag:v", "hvc1",
+	}
+	return append(args, extraArgs(opts)...)
+}
+
+// av1Encoder はソフトウェアAV1エンコード (libsvtav1) です。
+type av1Encoder struct{ ffmpegCodecEncoder }
+
+func newAV1Encoder() *av1Encoder { return &av1Encoder{ffmpegCodecEncoder{"libsvtav1"}} }
+
+func (e *av1Encoder) Name() string { return "av1" }
+
+func (e *av1Encoder) BuildArgs(opts EncoderOptions) []string {
+	preset := opts.Preset
+	if preset == "" || preset == "faster" {
+		preset = "8" // SVT-AV1は0(最高品質/最遅)〜13(最速)の数値プリセット
+	}
+	args := []string{"-vcodec", "libsvtav1", "-preset", preset}
+	args = append(args, rateControlArgs(opts)...)
+	return append(args, extraArgs(opts)...)
+}
+
+// rateControlArgs はCRFベースのソフトウェアエンコーダ(x264/x265/av1)共通のレート制御引数を
+// 組み立てます。opts.BitrateBpsが指定されていれば-b:vによるビットレート指定に切り替え、
+// そうでなければ-crfを使います。ffmpegのlibx264/libx265/libsvtav1は-crfと-b:vが両方渡されると
+// CRFモードを優先してビットレート指定を無視するため、2pass時は-crfを付けてはいけません。
+func rateControlArgs(opts EncoderOptions) []string {
+	if opts.BitrateBps != nil {
+		return []string{"-b:v", fmt.Sprintf("%d", *opts.BitrateBps)}
+	}
+	return []string{"-crf", fmt.Sprintf("%d", opts.CRF)}
+}
+
+// extraArgs はskipで指定したキー以外の --encoder-opt を "-key value" として末尾に追加します。
+// 出力を決定的にするためキーをソートします。
+func extraArgs(opts EncoderOptions, skip ...string) []string {
+	skipSet := make(map[string]bool, len(skip))
+	for _, k := range skip {
+		skipSet[k] = true
+	}
+
+	keys := make([]string, 0, len(opts.Extra))
+	for k := range opts.Extra {
+		if !skipSet[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, k := range keys {
+		args = append(args, "-"+k, opts.Extra[k])
+	}
+	return args
+}
+
+// encoderRegistry は --encoder で選択可能なエンコーダの一覧です。
+var encoderRegistry = map[string]func() Encoder{
+	"x264":         func() Encoder { return newX264Encoder() },
+	"x265":         func() Encoder { return newX265Encoder() },
+	"nvenc":        func() Encoder { return newNvencEncoder() },
+	"videotoolbox": func() Encoder { return newVideotoolboxEncoder() },
+	"av1":          func() Encoder { return newAV1Encoder() },
+}
+
+// encoderNames はヘルプ表示・一覧表示用にソート済みのエンコーダ名を返します。
+func encoderNames() []string {
+	names := make([]string, 0, len(encoderRegistry))
+	for name := range encoderRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveEncoder は --encoder で指定された名前からEncoderを生成します。
+func resolveEncoder(name string) (Encoder, error) {
+	ctor, ok := encoderRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("不明なエンコーダです: %q (利用可能: %s)", name, strings.Join(encoderNames(), ", "))
+	}
+	return ctor(), nil
+}
+
+// parseEncoderOpts は --encoder-opt key=value の繰り返し指定をmapにまとめます。
+func parseEncoderOpts(raw []string) (map[string]string, error) {
+	opts := make(map[string]string, len(raw))
+	for _, kv := range raw {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("--encoder-opt は key=value 形式で指定してください: %q", kv)
+		}
+		opts[key] = value
+	}
+	return opts, nil
+}
+
+// listAvailableEncoders は `ffmpeg -encoders` の出力を読み、
+// rec-watchが知っている各エンコーダがホスト上のffmpegで利用可能かを調べます。
+func listAvailableEncoders(ffmpegPath string) (map[string]bool, error) {
+	cmd := exec.Command(ffmpegPath, "-hide_banner", "-encoders")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg -encoders の実行に失敗しました: %w", err)
+	}
+
+	present := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		// 行頭はフラグ列 (例: "V....D")、2番目の項目がエンコーダ名
+		present[fields[1]] = true
+	}
+
+	available := make(map[string]bool, len(encoderRegistry))
+	for _, name := range encoderNames() {
+		enc, _ := resolveEncoder(name)
+		if fce, ok := enc.(interface{ ffmpegName() string }); ok {
+			available[name] = present[fce.ffmpegName()]
+		}
+	}
+	return available, nil
+}
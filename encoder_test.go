@@ -0,0 +1,96 @@
+package main
+
+import "testing"
+
+func containsArg(args []string, flag, value string) bool {
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == flag && args[i+1] == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFlag(args []string, flag string) bool {
+	for _, a := range args {
+		if a == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// TestBuildArgsOmitsCRFWhenBitrateTargeted は、2passエンコード時(BitrateBps指定時)に
+// -crfと-b:vが両方付いてCRFモードが優先されてしまう回帰を防ぐためのテストです。
+func TestBuildArgsOmitsCRFWhenBitrateTargeted(t *testing.T) {
+	bps := int64(4_000_000)
+	cases := []struct {
+		name    string
+		encoder Encoder
+	}{
+		{"x264", newX264Encoder()},
+		{"x265", newX265Encoder()},
+		{"av1", newAV1Encoder()},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			args := c.encoder.BuildArgs(EncoderOptions{CRF: 22, Preset: "faster", BitrateBps: &bps})
+			if containsFlag(args, "-crf") {
+				t.Errorf("%s.BuildArgs with BitrateBps set should not include -crf, got %v", c.name, args)
+			}
+			if !containsArg(args, "-b:v", "4000000") {
+				t.Errorf("%s.BuildArgs with BitrateBps set should include -b:v 4000000, got %v", c.name, args)
+			}
+		})
+	}
+}
+
+// TestBuildArgsUsesCRFByDefault はBitrateBps未指定時、従来どおり-crfを使うことを確認します。
+func TestBuildArgsUsesCRFByDefault(t *testing.T) {
+	cases := []struct {
+		name    string
+		encoder Encoder
+	}{
+		{"x264", newX264Encoder()},
+		{"x265", newX265Encoder()},
+		{"av1", newAV1Encoder()},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			args := c.encoder.BuildArgs(EncoderOptions{CRF: 22, Preset: "faster"})
+			if !containsArg(args, "-crf", "22") {
+				t.Errorf("%s.BuildArgs without BitrateBps should include -crf 22, got %v", c.name, args)
+			}
+			if containsFlag(args, "-b:v") {
+				t.Errorf("%s.BuildArgs without BitrateBps should not include -b:v, got %v", c.name, args)
+			}
+		})
+	}
+}
+
+// TestSupportsTwoPass はハードウェアエンコーダが2passのstatsファイル方式に非対応と
+// 報告することを確認します(convertTwoPassの拒否ロジックが前提にしている契約)。
+func TestSupportsTwoPass(t *testing.T) {
+	cases := []struct {
+		name    string
+		encoder Encoder
+		want    bool
+	}{
+		{"x264", newX264Encoder(), true},
+		{"x265", newX265Encoder(), true},
+		{"av1", newAV1Encoder(), true},
+		{"nvenc", newNvencEncoder(), false},
+		{"videotoolbox", newVideotoolboxEncoder(), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tpc, ok := c.encoder.(interface{ SupportsTwoPass() bool })
+			if !ok {
+				t.Fatalf("%s does not implement SupportsTwoPass", c.name)
+			}
+			if got := tpc.SupportsTwoPass(); got != c.want {
+				t.Errorf("%s.SupportsTwoPass() = %v, want %v", c.name, got, c.want)
+			}
+		})
+	}
+}
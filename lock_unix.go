@@ -0,0 +1,25 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// waitForHandleRelease は排他的にファイルを開き、さらにflockを試みることで、
+// レコーダーがファイルハンドルを解放し終えたことを確認します。
+func waitForHandleRelease(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("ファイルを排他的に開けません (書き込み中の可能性): %w", err)
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return fmt.Errorf("ファイルのロック取得に失敗 (書き込み中の可能性): %w", err)
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// waitForHandleRelease は排他的にファイルを開くことで、レコーダーがファイルハンドルを
+// 解放し終えたことを確認します。Windowsでは他プロセスが書き込み中のファイルは
+// os.OpenFile自体が共有違反で失敗するため、flock相当の追加ロックは不要です。
+func waitForHandleRelease(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("ファイルを排他的に開けません (書き込み中の可能性): %w", err)
+	}
+	return f.Close()
+}
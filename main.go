@@ -18,20 +18,33 @@ import (
 )
 
 var (
-	dest         string
-	crf          int
-	preset       string
-	fps          int
-	mute         bool
-	keywords     []string
-	noPad        bool
-	stampPerFile bool
-	noTrash      bool
-	batchStamp   bool
-	ffmpegBin    string
-	concurrent   int
-	watch        bool
-	notify       bool
+	dest           string
+	crf            int
+	preset         string
+	fps            int
+	mute           bool
+	keywords       []string
+	noPad          bool
+	stampPerFile   bool
+	noTrash        bool
+	batchStamp     bool
+	ffmpegBin      string
+	ffprobeBin     string
+	encoderName    string
+	encoderOpts    []string
+	updateFfmpeg   bool
+	concurrent     int
+	stableInterval time.Duration
+	stableSamples  int
+	stableTimeout  time.Duration
+	includeGlobs   []string
+	excludeGlobs   []string
+	maxDepth       int
+	dryRunTrash    bool
+	targetBitrate  string
+	targetSize     string
+	watch          bool
+	notify         bool
 )
 
 var rootCmd = &cobra.Command{
@@ -166,6 +179,7 @@ var rootCmd = &cobra.Command{
 		// 4. 並列変換処理
 		var wg sync.WaitGroup
 		semaphore := make(chan struct{}, concurrent)
+		reporter := newProgressReporter()
 
 		for _, inPath := range filteredFiles {
 			wg.Add(1)
@@ -176,55 +190,23 @@ var rootCmd = &cobra.Command{
 					<-semaphore // 実行枠を解放
 					wg.Done()
 				}()
-				if _, err := convertOne(inPath, batchDir); err != nil {
+				if _, err := convertOne(inPath, batchDir, reporter); err != nil {
 					log.Printf("❌ 変換失敗: %s -> %v", inPath, err)
 				}
 			}(inPath)
 		}
 
 		wg.Wait() // すべてのゴルーチンの完了を待つ
+		reporter.wait()
 		log.Println("✅ すべて完了")
 	},
 }
 
-// moveToTrash はファイルを各OSのゴミ箱に移動します。
-func moveToTrash(path string) error {
-	absPath, err := filepath.Abs(path)
-	if err != nil {
-		return err
-	}
-
-	switch runtime.GOOS {
-	case "darwin": // macOS
-		// macOSではAppleScriptを使うのが最も確実
-		cmd := exec.Command("osascript", "-e", `tell application "Finder" to move POSIX file "`+absPath+`" to trash`)
-		return cmd.Run()
-	case "linux":
-		// freedesktop.orgの仕様に準拠した`gio`コマンドを探す
-		if _, err := exec.LookPath("gio"); err == nil {
-			cmd := exec.Command("gio", "trash", absPath)
-			return cmd.Run()
-		}
-		// `gio`がない場合のフォールバック（より多くの環境で動作する可能性がある）
-		// ここでは単純化のため、gioのみをサポート対象とします。
-		return fmt.Errorf("gio command not found")
-	case "windows":
-		// Windowsでは外部ライブラリを使うのが一般的ですが、
-		// ここではPowerShellのコマンドレットを呼び出すことで対応します。
-		// この方法はPowerShell 5.0以降が必要です。
-		psCmd := fmt.Sprintf("Add-Type -AssemblyName Microsoft.VisualBasic; [Microsoft.VisualBasic.FileIO.FileSystem]::DeleteFile('%s', [Microsoft.VisualBasic.FileIO.UIOption]::OnlyErrorDialogs, [Microsoft.VisualBasic.FileIO.RecycleOption]::SendToRecycleBin)", absPath)
-		cmd := exec.Command("powershell", "-Command", psCmd)
-		return cmd.Run()
-	default:
-		return fmt.Errorf("%s はサポートされていないOSです", runtime.GOOS)
-	}
-}
-
 func nowStamp() string {
 	return time.Now().Format("20060102")
 }
 
-func convertOne(inPath string, outDir string) (string, error) {
+func convertOne(inPath string, outDir string, reporter *progressReporter) (string, error) {
 
 	// ファイルの更新日時を取得してファイル名にする
 	info, err := os.Stat(inPath)
@@ -243,38 +225,60 @@ func convertOne(inPath string, outDir string) (string, error) {
 		vf += ",pad=1920:1080:(ow-iw)/2:(oh-ih)/2"
 	}
 
-	ffmpegPath := "ffmpeg"
-	if ffmpegBin != "" {
-		ffmpegPath = ffmpegBin
-	}
+	ffmpegPath := resolveFfmpegPath()
 
-	ffmpegArgs := []string{
-		"-i", inPath,
-		"-vcodec", "libx264",
-		"-preset", preset,
-		"-crf", fmt.Sprintf("%d", crf),
-		"-vf", vf,
-		"-movflags", "+faststart",
+	// 進捗表示用に動画長を先に取得しておく(失敗しても変換自体は続行する)
+	label := filepath.Base(inPath)
+	total, err := probeDuration(resolveFfprobeBin(), inPath)
+	if err != nil {
+		log.Printf("⚠ 動画長の取得に失敗しました (進捗は概算になります): %s -> %v", inPath, err)
 	}
+	reporter.addFile(label, total)
+	// resolveEncoder以降はどの経路で抜けてもバーを終了させ、
+	// reporter.wait() が完了していないバーを待って無期限にブロックしないようにする。
+	defer reporter.finishFile(label)
 
-	// ... existing code from previous response
-	if fps > 0 {
-		ffmpegArgs = append(ffmpegArgs, "-r", fmt.Sprintf("%d", fps))
+	encoder, err := resolveEncoder(encoderName)
+	if err != nil {
+		return "", err
+	}
+	extra, err := parseEncoderOpts(encoderOpts)
+	if err != nil {
+		return "", err
+	}
+	if err := encoder.Validate(ffmpegPath); err != nil {
+		return "", err
 	}
 
-	if mute {
-		ffmpegArgs = append(ffmpegArgs, "-an")
+	if targetBitrate != "" || targetSize != "" {
+		log.Printf("▶ 2passエンコード: %s -> %s", inPath, outPath)
+		if err := convertTwoPass(ffmpegPath, inPath, outPath, vf, label, encoder, extra, total, reporter); err != nil {
+			return "", err
+		}
 	} else {
-		ffmpegArgs = append(ffmpegArgs, "-acodec", "aac", "-b:a", "128k", "-ac", "2")
-	}
+		ffmpegArgs := []string{"-i", inPath}
+		ffmpegArgs = append(ffmpegArgs, encoder.BuildArgs(EncoderOptions{CRF: crf, Preset: preset, Extra: extra})...)
+		ffmpegArgs = append(ffmpegArgs,
+			"-vf", vf,
+			"-movflags", "+faststart",
+		)
+
+		if fps > 0 {
+			ffmpegArgs = append(ffmpegArgs, "-r", fmt.Sprintf("%d", fps))
+		}
+
+		if mute {
+			ffmpegArgs = append(ffmpegArgs, "-an")
+		} else {
+			ffmpegArgs = append(ffmpegArgs, "-acodec", "aac", "-b:a", "128k", "-ac", "2")
+		}
 
-	ffmpegArgs = append(ffmpegArgs, outPath)
+		ffmpegArgs = append(ffmpegArgs, "-progress", "pipe:1", "-nostats", outPath)
 
-	log.Printf("▶ 変換: %s -> %s", inPath, outPath)
-	cmd := exec.Command(ffmpegPath, ffmpegArgs...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("ffmpeg実行エラー: %v\n%s", err, string(output))
+		log.Printf("▶ 変換: %s -> %s", inPath, outPath)
+		if err := runFfmpeg(ffmpegPath, ffmpegArgs, label, reporter); err != nil {
+			return "", err
+		}
 	}
 
 	if !noTrash {
@@ -299,11 +303,15 @@ func runWatchMode(dir string) {
 	}
 
 	done := make(chan bool)
+	reporter := newProgressReporter()
 
 	// 重複処理防止用のマップ
 	var processingMu sync.Mutex
 	processing := make(map[string]bool)
 
+	// Writeイベントのバーストをデバウンスするためのタイマー集合(絶対パスがキー)
+	debouncer := newEventDebouncer(stableInterval)
+
 	go func() {
 		for {
 			select {
@@ -311,88 +319,44 @@ func runWatchMode(dir string) {
 				if !ok {
 					return
 				}
-				// ファイル作成または書き込み完了を検知
-				// 注意: 画面収録ソフトによっては、書き込み中に何度もWriteイベントが発生する可能性があるため
-				// 本来はデバウンス処理が必要ですが、簡易的にCreateとRename(移動してきた場合)を監視します。
-				// また、大きなファイルの場合は書き込み完了を待つ必要があります。
-				if event.Op&fsnotify.Create == fsnotify.Create || event.Op&fsnotify.Rename == fsnotify.Rename {
-					fName := filepath.Base(event.Name)
-					if strings.HasPrefix(fName, ".") {
-						continue // 隠しファイルは無視
-					}
 
-					ext := strings.ToLower(filepath.Ext(fName))
-					isVideo := false
-					for _, v := range []string{".mov", ".mp4", ".m4v", ".avi", ".mkv"} {
-						if ext == v {
-							isVideo = true
-							break
+				// ディレクトリの新規作成/削除/移動は、動画ファイルとしてではなく
+				// 監視対象ツリーの変化として扱う。
+				if event.Op&fsnotify.Create == fsnotify.Create {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+						log.Printf("新しいディレクトリを検知、監視に追加: %s", event.Name)
+						if err := watchDirRecursive(watcher, absDir, event.Name, maxDepth, excludeGlobs); err != nil {
+							log.Printf("⚠ %v", err)
 						}
-					}
-					if !isVideo {
-						continue
-					}
-
-					log.Printf("新規ファイルを検知: %s", event.Name)
-
-					// ファイル書き込み完了を簡易的に待機 (サイズが変化しなくなるまで待つなど)
-					// ここでは単純に少し待つ
-					time.Sleep(2 * time.Second)
-
-					// ファイルが存在するか確認 (ゴミ箱に移動された場合などはここで弾く)
-					if _, err := os.Stat(event.Name); os.IsNotExist(err) {
-						log.Printf("ファイルが見つかりません (削除または移動されました): %s", event.Name)
-						continue
-					}
-
-					// 処理中チェック
-					processingMu.Lock()
-					if processing[event.Name] {
-						processingMu.Unlock()
-						log.Printf("すでに処理中です: %s", event.Name)
-						continue
-					}
-					processing[event.Name] = true
-					processingMu.Unlock()
-
-					// 処理完了後にフラグを落とす
-					defer func(name string) {
-						processingMu.Lock()
-						delete(processing, name)
-						processingMu.Unlock()
-					}(event.Name)
-
-					// 出力先
-					baseOut, _ := filepath.Abs(dest)
-					batchDir := baseOut
-					if batchStamp {
-						batchDir = filepath.Join(baseOut, nowStamp())
-					}
-					if err := os.MkdirAll(batchDir, 0755); err != nil {
-						log.Printf("出力ディレクトリ作成失敗: %v", err)
 						continue
 					}
+				}
+				if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+					// ファイルかディレクトリか分からなくなっているので、
+					// 監視済みであればエラーは無視して外す。
+					_ = watcher.Remove(event.Name)
+				}
 
-					// 絶対パスに変換してから渡す
-					absPath, err := filepath.Abs(event.Name)
-					if err != nil {
-						log.Printf("パスの解決に失敗: %v", err)
-						continue
-					}
+				// Create/Rename/Writeいずれも検知対象とし、デバウンスで束ねてから処理する。
+				// 書き込み完了の判定自体は processDetectedFile 内の安定待機が行う。
+				if event.Op&(fsnotify.Create|fsnotify.Rename|fsnotify.Write) == 0 {
+					continue
+				}
 
-					log.Printf("変換開始: %s", absPath)
-					if outPath, err := convertOne(absPath, batchDir); err != nil {
-						log.Printf("❌ 変換失敗: %v", err)
-						if notify {
-							sendNotification("変換失敗", fmt.Sprintf("%s の変換に失敗しました。", fName), "")
-						}
-					} else {
-						log.Printf("✅ 変換完了: %s", event.Name)
-						if notify {
-							sendNotification("変換完了", fmt.Sprintf("%s を変換しました。", fName), outPath)
-						}
-					}
+				fName := filepath.Base(event.Name)
+				if strings.HasPrefix(fName, ".") {
+					continue // 隠しファイルは無視
+				}
+				if !isWatchedVideoExt(fName) {
+					continue
+				}
+				if !matchesWatchFilters(absDir, event.Name, includeGlobs, excludeGlobs) {
+					continue
 				}
+
+				debouncer.schedule(event.Name, func() {
+					processDetectedFile(event.Name, reporter, &processingMu, processing)
+				})
 			case err, ok := <-watcher.Errors:
 				if !ok {
 					return
@@ -402,14 +366,89 @@ func runWatchMode(dir string) {
 		}
 	}()
 
-	err = watcher.Add(absDir)
-	if err != nil {
+	if err := watchDirRecursive(watcher, absDir, absDir, maxDepth, excludeGlobs); err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("監視を開始しました: %s", absDir)
+	log.Printf("監視を開始しました: %s (再帰的)", absDir)
 	<-done
 }
 
+// isWatchedVideoExt は監視対象とする動画拡張子かどうかを返します。
+func isWatchedVideoExt(fName string) bool {
+	ext := strings.ToLower(filepath.Ext(fName))
+	for _, v := range []string{".mov", ".mp4", ".m4v", ".avi", ".mkv"} {
+		if ext == v {
+			return true
+		}
+	}
+	return false
+}
+
+// processDetectedFile は検知したファイルの書き込み完了を待ってから変換します。
+func processDetectedFile(path string, reporter *progressReporter, processingMu *sync.Mutex, processing map[string]bool) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		log.Printf("ファイルが見つかりません (削除または移動されました): %s", path)
+		return
+	}
+
+	processingMu.Lock()
+	if processing[path] {
+		processingMu.Unlock()
+		log.Printf("すでに処理中です: %s", path)
+		return
+	}
+	processing[path] = true
+	processingMu.Unlock()
+	defer func() {
+		processingMu.Lock()
+		delete(processing, path)
+		processingMu.Unlock()
+	}()
+
+	log.Printf("書き込み完了を待機中: %s", path)
+	if err := waitForStableFile(path, stableInterval, stableSamples, stableTimeout); err != nil {
+		log.Printf("⚠ %v (このまま変換を試みます)", err)
+	}
+	if err := waitForHandleRelease(path); err != nil {
+		log.Printf("⚠ %v (このまま変換を試みます)", err)
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		log.Printf("ファイルが見つかりません (削除または移動されました): %s", path)
+		return
+	}
+
+	baseOut, _ := filepath.Abs(dest)
+	batchDir := baseOut
+	if batchStamp {
+		batchDir = filepath.Join(baseOut, nowStamp())
+	}
+	if err := os.MkdirAll(batchDir, 0755); err != nil {
+		log.Printf("出力ディレクトリ作成失敗: %v", err)
+		return
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		log.Printf("パスの解決に失敗: %v", err)
+		return
+	}
+	fName := filepath.Base(absPath)
+
+	log.Printf("変換開始: %s", absPath)
+	if outPath, err := convertOne(absPath, batchDir, reporter); err != nil {
+		log.Printf("❌ 変換失敗: %v", err)
+		if notify {
+			sendNotification("変換失敗", fmt.Sprintf("%s の変換に失敗しました。", fName), "")
+		}
+	} else {
+		log.Printf("✅ 変換完了: %s", absPath)
+		if notify {
+			sendNotification("変換完了", fmt.Sprintf("%s を変換しました。", fName), outPath)
+		}
+	}
+}
+
 func sendNotification(title, message, filePath string) {
 	// terminal-notifierがインストールされているか確認
 	if _, err := exec.LookPath("terminal-notifier"); err == nil {
@@ -434,6 +473,37 @@ func sendNotification(title, message, filePath string) {
 	}
 }
 
+var listEncodersCmd = &cobra.Command{
+	Use:   "list-encoders",
+	Short: "利用可能なエンコーダの一覧を表示します。",
+	Run: func(cmd *cobra.Command, args []string) {
+		available, err := listAvailableEncoders(resolveFfmpegPath())
+		if err != nil {
+			log.Fatalf("エンコーダ一覧の取得に失敗: %v", err)
+		}
+		for _, name := range encoderNames() {
+			mark := "✗"
+			if available[name] {
+				mark = "✓"
+			}
+			fmt.Printf("%s %s\n", mark, name)
+		}
+	},
+}
+
+var setupCmd = &cobra.Command{
+	Use:   "setup",
+	Short: "ffmpeg/ffprobeを自動ダウンロードしてセットアップします。",
+	Long: `PATH上にffmpegが見つからず --ffmpeg-bin も指定されていない場合に、
+実行環境(` + runtime.GOOS + `/` + runtime.GOARCH + `)向けの静的ビルドをダウンロードして
+~/.cache/rec-watch/bin/ に展開し、解決したパスを ~/.config/rec-watch/config.yaml に記録します。`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runSetup(updateFfmpeg); err != nil {
+			log.Fatalf("セットアップに失敗しました: %v\n手動で --ffmpeg-bin にパスを指定することもできます。", err)
+		}
+	},
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -442,6 +512,10 @@ func Execute() {
 }
 
 func init() {
+	rootCmd.AddCommand(listEncodersCmd)
+	rootCmd.AddCommand(setupCmd)
+	setupCmd.Flags().BoolVar(&updateFfmpeg, "update-ffmpeg", false, "既にffmpegが見つかっていても再ダウンロードする")
+
 	// デフォルト値の取得
 	cwd, _ := os.Getwd()
 	defaultDest := filepath.Join(cwd, "out")
@@ -462,9 +536,21 @@ func init() {
 	rootCmd.Flags().BoolVar(&noTrash, "no-trash", false, "変換元のファイルをゴミ箱に移動しない")
 	rootCmd.Flags().BoolVar(&batchStamp, "batch-stamp", true, "出力先ディレクトリをタイムスタンプ付きで作成する")
 	rootCmd.Flags().StringVar(&ffmpegBin, "ffmpeg-bin", "", "ffmpegのバイナリパスを明示的に指定する")
+	rootCmd.Flags().StringVar(&ffprobeBin, "ffprobe-bin", "", "ffprobeのバイナリパスを明示的に指定する (進捗表示の動画長取得に使用)")
+	rootCmd.Flags().StringVar(&encoderName, "encoder", "x264", fmt.Sprintf("使用するエンコーダ (%s)", strings.Join(encoderNames(), ", ")))
+	rootCmd.Flags().StringArrayVar(&encoderOpts, "encoder-opt", nil, "エンコーダ固有オプション key=value (繰り返し指定可)")
 	rootCmd.Flags().IntVar(&concurrent, "concurrent", defaultConcurrent, "並列実行数")
 	rootCmd.Flags().BoolVar(&watch, "watch", false, "指定したディレクトリを監視して自動変換する")
 	rootCmd.Flags().BoolVar(&notify, "notify", true, "変換完了時にデスクトップ通知を送る (watchモード時など)")
+	rootCmd.Flags().DurationVar(&stableInterval, "stable-interval", 500*time.Millisecond, "watchモードでファイルサイズ/更新時刻をポーリングする間隔")
+	rootCmd.Flags().IntVar(&stableSamples, "stable-samples", 4, "watchモードで書き込み完了とみなすまでの連続安定サンプル数")
+	rootCmd.Flags().DurationVar(&stableTimeout, "stable-timeout", 2*time.Minute, "watchモードで書き込み安定を待つ最大時間")
+	rootCmd.Flags().StringArrayVar(&includeGlobs, "include", nil, "watchモードで対象に含めるglobパターン (監視ルートからの相対パス, 繰り返し指定可)")
+	rootCmd.Flags().StringArrayVar(&excludeGlobs, "exclude", nil, "watchモードで対象から除外するglobパターン (監視ルートからの相対パス, 繰り返し指定可)")
+	rootCmd.Flags().IntVar(&maxDepth, "max-depth", 0, "watchモードで再帰する最大の深さ (0で無制限)")
+	rootCmd.Flags().BoolVar(&dryRunTrash, "dry-run", false, "ゴミ箱への移動を実際には行わず、対象をログ出力するだけにする")
+	rootCmd.Flags().StringVar(&targetBitrate, "target-bitrate", "", "指定した映像ビットレートで2passエンコードする (例: 4M)")
+	rootCmd.Flags().StringVar(&targetSize, "target-size", "", "指定したファイルサイズに収まるよう2passエンコードする (例: 500MB, --target-bitrate未指定時のみ有効)")
 }
 
 func main() {
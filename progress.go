@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vbauerster/mpb/v8"
+	"github.com/vbauerster/mpb/v8/decor"
+	"golang.org/x/term"
+)
+
+// ffmpegProgress は `-progress pipe:1` が1ブロックごとに出力する
+// key=value群をまとめたものです。
+type ffmpegProgress struct {
+	OutTimeMs int64 // マイクロ秒 (ffmpegの仕様上 out_time_ms は実際にはus単位)
+	Frame     int64
+	FPS       float64
+	Bitrate   string
+	Speed     float64
+	Done      bool // progress=end を受け取った
+}
+
+// parseProgressStream は ffmpeg の `-progress pipe:1` 出力をブロック単位で読み取り、
+// onUpdate にブロックごとの進捗を渡します。
+func parseProgressStream(r io.Reader, onUpdate func(ffmpegProgress)) error {
+	scanner := bufio.NewScanner(r)
+	cur := ffmpegProgress{}
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		switch key {
+		case "out_time_ms":
+			cur.OutTimeMs, _ = strconv.ParseInt(value, 10, 64)
+		case "frame":
+			cur.Frame, _ = strconv.ParseInt(value, 10, 64)
+		case "fps":
+			cur.FPS, _ = strconv.ParseFloat(value, 64)
+		case "bitrate":
+			cur.Bitrate = value
+		case "speed":
+			cur.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "progress":
+			// このキーが各ブロックの終端マーカー
+			cur.Done = value == "end"
+			onUpdate(cur)
+			cur = ffmpegProgress{}
+		}
+	}
+	return scanner.Err()
+}
+
+// resolveFfprobeBin はffprobeの実行パスを決定します。
+// --ffprobe-bin が明示されていればそれを、次に setup で取得済みの config.yaml の
+// 記録を、次に --ffmpeg-bin と同じディレクトリのffprobeを、
+// どれもなければPATH上の "ffprobe" を使います。
+func resolveFfprobeBin() string {
+	if ffprobeBin != "" {
+		return ffprobeBin
+	}
+	if cfg, err := loadConfig(); err == nil && cfg.FfprobePath != "" {
+		if _, err := os.Stat(cfg.FfprobePath); err == nil {
+			return cfg.FfprobePath
+		}
+	}
+	if ffmpegBin != "" {
+		dir := filepath.Dir(ffmpegBin)
+		if dir != "." {
+			return filepath.Join(dir, "ffprobe"+filepath.Ext(ffmpegBin))
+		}
+	}
+	return "ffprobe"
+}
+
+// probeDuration はffprobeで入力ファイルの再生時間を取得します。
+func probeDuration(ffprobePath, inPath string) (time.Duration, error) {
+	cmd := exec.Command(ffprobePath,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		inPath,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe実行エラー: %w", err)
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("ffprobeの出力解析に失敗: %w", err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// fileTracker は1ファイル分の進捗バーと推定時間を保持します。
+type fileTracker struct {
+	bar   *mpb.Bar
+	total time.Duration
+}
+
+// progressReporter は複数ワーカーの変換進捗を集約し、マルチバーTUIとして描画します。
+// 標準出力がTTYでない場合はプレーンなログ行にフォールバックします。
+type progressReporter struct {
+	mu    sync.Mutex
+	prog  *mpb.Progress
+	bars  map[string]*fileTracker
+	plain bool
+}
+
+// newProgressReporter はプロセスの標準出力の状態に応じたレポーターを作ります。
+func newProgressReporter() *progressReporter {
+	isTTY := term.IsTerminal(int(os.Stdout.Fd()))
+	r := &progressReporter{bars: make(map[string]*fileTracker), plain: !isTTY}
+	if isTTY {
+		r.prog = mpb.New(mpb.WithWidth(40), mpb.WithRefreshRate(200*time.Millisecond))
+	}
+	return r
+}
+
+// addFile は1件の変換対象を進捗表示に登録します。totalが0の場合は割合ではなく経過時間のみ表示します。
+func (r *progressReporter) addFile(label string, total time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := &fileTracker{total: total}
+	if !r.plain {
+		barTotal := total.Milliseconds()
+		if barTotal <= 0 {
+			barTotal = 1
+		}
+		t.bar = r.prog.AddBar(barTotal,
+			mpb.PrependDecorators(decor.Name(label, decor.WC{W: len(label) + 1, C: decor.DSyncSpaceR})),
+			mpb.AppendDecorators(
+				decor.Percentage(decor.WC{W: 5}),
+				decor.Name(" "),
+				decor.AverageETA(decor.ET_STYLE_MMSS),
+			),
+		)
+	}
+	r.bars[label] = t
+}
+
+// onUpdate はffmpegの進捗ブロックを1件分の表示に反映します。
+func (r *progressReporter) onUpdate(label string, p ffmpegProgress) {
+	r.mu.Lock()
+	t := r.bars[label]
+	r.mu.Unlock()
+	if t == nil {
+		return
+	}
+
+	elapsed := time.Duration(p.OutTimeMs) * time.Microsecond
+	if r.plain {
+		pct := 0.0
+		if t.total > 0 {
+			pct = float64(elapsed) / float64(t.total) * 100
+		}
+		log.Printf("⏳ %s: %.1f%% フレーム=%d fps=%.1f 速度=%.2fx ビットレート=%s", label, pct, p.Frame, p.FPS, p.Speed, p.Bitrate)
+		return
+	}
+	t.bar.SetCurrent(elapsed.Milliseconds())
+}
+
+// finishFile はバーを満了状態にします(動画長が取得できず途中経過と長さがズレていた場合の補正)。
+func (r *progressReporter) finishFile(label string) {
+	r.mu.Lock()
+	t := r.bars[label]
+	r.mu.Unlock()
+	if t != nil && t.bar != nil && !t.bar.Completed() {
+		t.bar.SetCurrent(t.bar.Current())
+		t.bar.Abort(false)
+	}
+}
+
+// wait はすべてのバーの描画が完了するまでブロックします。バッチ処理の最後に呼び出してください。
+// 監視モードのような終わりのない実行では呼び出す必要はありません。
+func (r *progressReporter) wait() {
+	if r.prog != nil {
+		r.prog.Wait()
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+	"github.com/vbauerster/mpb/v8"
+	"github.com/vbauerster/mpb/v8/decor"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+// rwConfig は ~/.config/rec-watch/config.yaml の内容です。
+// setupサブコマンドが解決したバイナリパスを記録し、通常の実行時に再利用します。
+type rwConfig struct {
+	FfmpegPath  string `yaml:"ffmpeg_path"`
+	FfprobePath string `yaml:"ffprobe_path"`
+}
+
+// configPath は設定ファイルのパスを返します (通常 ~/.config/rec-watch/config.yaml)。
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "rec-watch", "config.yaml"), nil
+}
+
+func loadConfig() (*rwConfig, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &rwConfig{}, nil
+		}
+		return nil, err
+	}
+	var cfg rwConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("設定ファイルの解析に失敗: %w", err)
+	}
+	return &cfg, nil
+}
+
+func saveConfig(cfg *rwConfig) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// binCacheDir はダウンロードしたバイナリの展開先 (通常 ~/.cache/rec-watch/bin) です。
+func binCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "rec-watch", "bin"), nil
+}
+
+// resolveFfmpegPath は --ffmpeg-bin > setupで取得済みのキャッシュ > PATH の順に解決します。
+func resolveFfmpegPath() string {
+	if ffmpegBin != "" {
+		return ffmpegBin
+	}
+	if cfg, err := loadConfig(); err == nil && cfg.FfmpegPath != "" {
+		if _, err := os.Stat(cfg.FfmpegPath); err == nil {
+			return cfg.FfmpegPath
+		}
+	}
+	return "ffmpeg"
+}
+
+func ffmpegBinaryName() string {
+	if runtime.GOOS == "windows" {
+		return "ffmpeg.exe"
+	}
+	return "ffmpeg"
+}
+
+func ffprobeBinaryName() string {
+	if runtime.GOOS == "windows" {
+		return "ffprobe.exe"
+	}
+	return "ffprobe"
+}
+
+// releaseAsset は現在のGOOS/GOARCHに対応する静的ビルドの配布物です。
+type releaseAsset struct {
+	url      string
+	filename string
+}
+
+// ffmpegAssetFor はBtbN/FFmpeg-Builds (Linux/Windows) またはevermeet.cx (macOS)
+// の、現在のホストに合った最新静的ビルドのURLを返します。
+func ffmpegAssetFor(goos, goarch string) (releaseAsset, error) {
+	const btbnBase = "https://github.com/BtbN/FFmpeg-Builds/releases/latest/download"
+
+	switch goos {
+	case "linux":
+		arch := "linux64"
+		if goarch == "arm64" {
+			arch = "linuxarm64"
+		}
+		filename := fmt.Sprintf("ffmpeg-master-latest-%s-gpl.tar.xz", arch)
+		return releaseAsset{url: btbnBase + "/" + filename, filename: filename}, nil
+	case "windows":
+		arch := "win64"
+		if goarch == "386" {
+			arch = "win32"
+		}
+		filename := fmt.Sprintf("ffmpeg-master-latest-%s-gpl.zip", arch)
+		return releaseAsset{url: btbnBase + "/" + filename, filename: filename}, nil
+	case "darwin":
+		return releaseAsset{url: "https://evermeet.cx/ffmpeg/getrelease/zip", filename: "ffmpeg-evermeet.zip"}, nil
+	default:
+		return releaseAsset{}, fmt.Errorf("%s 向けの自動ダウンロードには対応していません", goos)
+	}
+}
+
+// downloadFile はurlの内容をdestPathに保存しつつ、進捗バーを表示します。
+func downloadFile(url, destPath string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+
+	out, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	isTTY := term.IsTerminal(int(os.Stdout.Fd()))
+	if !isTTY || resp.ContentLength <= 0 {
+		_, err = io.Copy(out, resp.Body)
+		return err
+	}
+
+	p := mpb.New(mpb.WithWidth(40))
+	bar := p.AddBar(resp.ContentLength,
+		mpb.PrependDecorators(decor.Name(filepath.Base(destPath))),
+		mpb.AppendDecorators(decor.CountersKibiByte("% .1f / % .1f")),
+	)
+	reader := bar.ProxyReader(resp.Body)
+	defer reader.Close()
+	_, err = io.Copy(out, reader)
+	p.Wait()
+	return err
+}
+
+// fetchChecksum は配布元が公開しているこのビルド用のSHA256チェックサムを取得します。
+// BtbNとevermeet.cxでは配布方法が異なるため、配布元ごとに実際に存在するエンドポイントから
+// 取得します(<asset>.sha256 という併置ファイルは両者とも提供していません)。
+// なお、いずれも同じ配布元ホストを情報源にするため、ホスト自体が侵害された場合までは
+// 守れません。あて推量のURLを叩いて検証をスキップするよりは確実、という位置づけです。
+func fetchChecksum(goos string, asset releaseAsset) (string, error) {
+	if goos == "darwin" {
+		return fetchEvermeetChecksum()
+	}
+	return fetchBtbNChecksum(asset)
+}
+
+// fetchEvermeetChecksum はevermeet.cxのドキュメント化されたinfo APIから、
+// 現行releaseビルドのSHA256を取得します。
+func fetchEvermeetChecksum() (string, error) {
+	resp, err := http.Get("https://evermeet.cx/ffmpeg/info/ffmpeg/release")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("evermeet.cxのinfo APIの取得に失敗しました (HTTP %s)", resp.Status)
+	}
+	var info struct {
+		Sha256 string `json:"sha256"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", fmt.Errorf("evermeet.cxのinfo APIの解析に失敗しました: %w", err)
+	}
+	if info.Sha256 == "" {
+		return "", fmt.Errorf("evermeet.cxのinfo APIにsha256が含まれていませんでした")
+	}
+	return strings.ToLower(info.Sha256), nil
+}
+
+// fetchBtbNChecksum はBtbN/FFmpeg-Buildsのリリースに同梱されている、
+// 全アセット分のチェックサムをまとめた checksums.sha256 からassetに該当する行を探します。
+func fetchBtbNChecksum(asset releaseAsset) (string, error) {
+	const checksumsURL = "https://github.com/BtbN/FFmpeg-Builds/releases/latest/download/checksums.sha256"
+	resp, err := http.Get(checksumsURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("checksums.sha256の取得に失敗しました (HTTP %s)", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(body), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if strings.TrimPrefix(fields[1], "*") == asset.filename {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("checksums.sha256に %s のエントリが見つかりませんでした", asset.filename)
+}
+
+func verifyChecksum(path, wantHex string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	got := hex.EncodeToString(h.Sum(nil))
+	if got != strings.ToLower(wantHex) {
+		return fmt.Errorf("SHA256が一致しません (got=%s want=%s)", got, wantHex)
+	}
+	return nil
+}
+
+// safeJoin はdestDir配下に展開するエントリ名(zip/tarのf.Name/hdr.Name)を解決します。
+// "../" を含む名前や絶対パスでdestDirの外に書き出そうとするアーカイブ(zip-slip)を拒否します。
+func safeJoin(destDir, name string) (string, error) {
+	target := filepath.Join(destDir, name)
+	rel, err := filepath.Rel(destDir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("アーカイブエントリがdestDirの外を指しています: %s", name)
+	}
+	return target, nil
+}
+
+// extractArchive は拡張子に応じて .zip / .tar.xz をdestDirへ展開します。
+func extractArchive(archivePath, destDir string) error {
+	switch {
+	case strings.HasSuffix(archivePath, ".zip"):
+		return extractZip(archivePath, destDir)
+	case strings.HasSuffix(archivePath, ".tar.xz"):
+		return extractTarXz(archivePath, destDir)
+	default:
+		return fmt.Errorf("未対応のアーカイブ形式です: %s", archivePath)
+	}
+}
+
+func extractZip(archivePath, destDir string) error {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		target, err := safeJoin(destDir, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := extractZipEntry(f, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZipEntry(f *zip.File, target string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, rc)
+	return err
+}
+
+func extractTarXz(archivePath, destDir string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	xr, err := xz.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("xzの展開に失敗: %w", err)
+	}
+
+	tr := tar.NewReader(xr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target, err := safeJoin(destDir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.Mode))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			out.Close()
+		}
+	}
+}
+
+// findBinary はdestDir配下を再帰的に探し、nameという名前のファイルを見つけたら
+// 実行権限を付与してその絶対パスを返します。
+func findBinary(destDir, name string) (string, error) {
+	var found string
+	err := filepath.Walk(destDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if found != "" || info.IsDir() {
+			return nil
+		}
+		if info.Name() == name {
+			found = path
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil && err != filepath.SkipAll {
+		return "", err
+	}
+	if found == "" {
+		return "", fmt.Errorf("%s が展開されたファイル群の中に見つかりませんでした", name)
+	}
+	if runtime.GOOS != "windows" {
+		if err := os.Chmod(found, 0755); err != nil {
+			return "", err
+		}
+	}
+	return found, nil
+}
+
+// runSetup はffmpeg/ffprobeの静的ビルドをダウンロード・展開し、config.yamlに記録します。
+// force が false の場合、既にPATH上やキャッシュに存在するなら何もしません。
+func runSetup(force bool) error {
+	if !force {
+		if ffmpegBin != "" {
+			log.Printf("--ffmpeg-bin が指定されているためセットアップは不要です: %s", ffmpegBin)
+			return nil
+		}
+		if _, err := exec.LookPath("ffmpeg"); err == nil {
+			log.Println("ffmpegはPATH上に見つかりました。セットアップは不要です。")
+			return nil
+		}
+		if cfg, err := loadConfig(); err == nil && cfg.FfmpegPath != "" {
+			if _, err := os.Stat(cfg.FfmpegPath); err == nil {
+				log.Printf("既にダウンロード済みです: %s (再取得するには --update-ffmpeg を指定してください)", cfg.FfmpegPath)
+				return nil
+			}
+		}
+	}
+
+	asset, err := ffmpegAssetFor(runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		return err
+	}
+
+	cacheDir, err := binCacheDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return err
+	}
+
+	archivePath := filepath.Join(cacheDir, asset.filename)
+	log.Printf("ダウンロード中: %s", asset.url)
+	if err := downloadFile(asset.url, archivePath); err != nil {
+		return fmt.Errorf("ダウンロードに失敗しました: %w", err)
+	}
+
+	sum, err := fetchChecksum(runtime.GOOS, asset)
+	if err != nil {
+		return fmt.Errorf("チェックサムを取得できなかったため展開を中止します (手動で --ffmpeg-bin を指定することもできます): %w", err)
+	}
+	if err := verifyChecksum(archivePath, sum); err != nil {
+		return fmt.Errorf("チェックサム検証に失敗しました: %w", err)
+	}
+
+	extractDir := filepath.Join(cacheDir, "extracted")
+	if err := extractArchive(archivePath, extractDir); err != nil {
+		return fmt.Errorf("展開に失敗しました: %w", err)
+	}
+
+	ffmpegPath, err := findBinary(extractDir, ffmpegBinaryName())
+	if err != nil {
+		return err
+	}
+	cfg := &rwConfig{FfmpegPath: ffmpegPath}
+	if ffprobePath, err := findBinary(extractDir, ffprobeBinaryName()); err == nil {
+		cfg.FfprobePath = ffprobePath
+	} else {
+		log.Printf("⚠ ffprobeの同梱バイナリが見つかりませんでした: %v", err)
+	}
+
+	if err := saveConfig(cfg); err != nil {
+		return err
+	}
+	log.Printf("✅ ffmpegをセットアップしました: %s", ffmpegPath)
+	return nil
+}
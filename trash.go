@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// moveToTrash はファイルをOSのゴミ箱に移動します。まずプロセス内で完結する
+// ネイティブ実装 (nativeTrash, OSごとにtrash_*.goで定義) を試み、
+// それが失敗した場合のみ従来の外部コマンド呼び出しにフォールバックします。
+// --dry-run が指定されている場合は何も移動せず、対象をログに出すだけです。
+func moveToTrash(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	if dryRunTrash {
+		log.Printf("🗑 [dry-run] ゴミ箱に移動する対象: %s", absPath)
+		return nil
+	}
+
+	if err := nativeTrash(absPath); err != nil {
+		log.Printf("ネイティブ実装でのゴミ箱移動に失敗、外部コマンドにフォールバックします: %v", err)
+		return shellTrash(absPath)
+	}
+	return nil
+}
+
+// shellTrash は各OSの外部コマンドを呼び出す従来のフォールバック実装です。
+func shellTrash(absPath string) error {
+	switch runtime.GOOS {
+	case "darwin": // macOS
+		// macOSではAppleScriptを使うのが最も確実
+		cmd := exec.Command("osascript", "-e", `tell application "Finder" to move POSIX file "`+absPath+`" to trash`)
+		return cmd.Run()
+	case "linux":
+		// freedesktop.orgの仕様に準拠した`gio`コマンドを探す
+		if _, err := exec.LookPath("gio"); err == nil {
+			cmd := exec.Command("gio", "trash", absPath)
+			return cmd.Run()
+		}
+		// `gio`がない場合のフォールバック（より多くの環境で動作する可能性がある）
+		// ここでは単純化のため、gioのみをサポート対象とします。
+		return fmt.Errorf("gio command not found")
+	case "windows":
+		// Windowsでは外部ライブラリを使うのが一般的ですが、
+		// ここではPowerShellのコマンドレットを呼び出すことで対応します。
+		// この方法はPowerShell 5.0以降が必要です。
+		psCmd := fmt.Sprintf("Add-Type -AssemblyName Microsoft.VisualBasic; [Microsoft.VisualBasic.FileIO.FileSystem]::DeleteFile('%s', [Microsoft.VisualBasic.FileIO.UIOption]::OnlyErrorDialogs, [Microsoft.VisualBasic.FileIO.RecycleOption]::SendToRecycleBin)", absPath)
+		cmd := exec.Command("powershell", "-Command", psCmd)
+		return cmd.Run()
+	default:
+		return fmt.Errorf("%s はサポートされていないOSです", runtime.GOOS)
+	}
+}
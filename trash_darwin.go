@@ -0,0 +1,38 @@
+//go:build darwin && cgo
+
+package main
+
+/*
+#cgo LDFLAGS: -framework Foundation
+#import <Foundation/Foundation.h>
+
+static int rw_trashItem(const char *cPath) {
+	@autoreleasepool {
+		NSString *path = [NSString stringWithUTF8String:cPath];
+		NSURL *url = [NSURL fileURLWithPath:path];
+		NSError *error = nil;
+		BOOL ok = [[NSFileManager defaultManager] trashItemAtURL:url resultingItemURL:nil error:&error];
+		return ok ? 1 : 0;
+	}
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// nativeTrash はNSFileManagerのtrashItemAtURL:resultingItemURL:error:を呼び出し、
+// Finderのゴミ箱に移動します。cgoが無効なビルド (CGO_ENABLED=0) ではこの実装は使えないため
+// trash_darwin_nocgo.go の実装を使います。失敗時は呼び出し元(moveToTrash)がosascript経由の
+// シェルフォールバックを行います。
+func nativeTrash(path string) error {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	if C.rw_trashItem(cPath) == 0 {
+		return fmt.Errorf("NSFileManager trashItemAtURL に失敗しました: %s", path)
+	}
+	return nil
+}
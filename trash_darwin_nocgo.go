@@ -0,0 +1,13 @@
+//go:build darwin && !cgo
+
+package main
+
+import "os/exec"
+
+// nativeTrash はcgoが無効なビルド (CGO_ENABLED=0、クロスコンパイルされたリリースビルドなど) 向けの
+// 実装です。プロセス内からFoundationを直接呼び出すことはできないため、AppleScript経由で
+// NSFileManagerのmoveItemAtPath相当の操作 (Finderの「ゴミ箱に入れる」) を行います。
+func nativeTrash(path string) error {
+	cmd := exec.Command("osascript", "-e", `tell application "Finder" to move POSIX file "`+path+`" to trash`)
+	return cmd.Run()
+}
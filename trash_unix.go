@@ -0,0 +1,184 @@
+//go:build linux || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// nativeTrash はfreedesktop.org Trash仕様に従い、ファイルを
+// $XDG_DATA_HOME/Trash/files/ (ホームと同じボリュームの場合) または
+// 当該ボリュームの $topdir/.Trash-$UID/files/ (別ボリュームの場合) に移動し、
+// 対応する .trashinfo を書き出します。
+func nativeTrash(path string) error {
+	trashDir, err := trashDirFor(path)
+	if err != nil {
+		return fmt.Errorf("ゴミ箱ディレクトリの決定に失敗: %w", err)
+	}
+
+	filesDir := filepath.Join(trashDir, "files")
+	infoDir := filepath.Join(trashDir, "info")
+	if err := os.MkdirAll(filesDir, 0700); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(infoDir, 0700); err != nil {
+		return err
+	}
+
+	name := uniqueTrashName(filesDir, filepath.Base(path))
+	destFile := filepath.Join(filesDir, name)
+	infoFile := filepath.Join(infoDir, name+".trashinfo")
+
+	info := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+		trashInfoPath(trashDir, path), time.Now().Format("2006-01-02T15:04:05"))
+	if err := os.WriteFile(infoFile, []byte(info), 0600); err != nil {
+		return err
+	}
+
+	if err := renameOrCopy(path, destFile); err != nil {
+		os.Remove(infoFile)
+		return err
+	}
+	return nil
+}
+
+// homeTrashDir はホームディレクトリと同じボリュームにあるファイル向けのゴミ箱です。
+func homeTrashDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "Trash"), nil
+}
+
+// trashDirFor はpathを捨てるべきゴミ箱ディレクトリを決定します。
+// ホームと同じデバイス上にあればホームのゴミ箱を、別デバイスであれば
+// そのボリュームの $topdir/.Trash-$UID を使います (freedesktop.org Trash仕様)。
+func trashDirFor(path string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	homeTrash, err := homeTrashDir()
+	if err != nil {
+		return "", err
+	}
+
+	same, err := sameDevice(filepath.Dir(path), home)
+	if err == nil && same {
+		return homeTrash, nil
+	}
+
+	topDir, err := findMountPoint(path)
+	if err != nil {
+		// ボリュームの検出に失敗した場合はホームのゴミ箱にフォールバックする
+		return homeTrash, nil
+	}
+	return filepath.Join(topDir, fmt.Sprintf(".Trash-%d", os.Getuid())), nil
+}
+
+// trashInfoPath は.trashinfoのPath=キーに書く値です。ホームのゴミ箱の場合は絶対パス、
+// ボリューム固有のゴミ箱の場合はtopdirからの相対パスにする必要があります。
+func trashInfoPath(trashDir, originalPath string) string {
+	if homeTrash, err := homeTrashDir(); err == nil && trashDir == homeTrash {
+		return originalPath
+	}
+	topDir := filepath.Dir(trashDir) // trashDir は <topdir>/.Trash-$UID
+	if rel, err := filepath.Rel(topDir, originalPath); err == nil {
+		return rel
+	}
+	return originalPath
+}
+
+func deviceOf(path string) (uint64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("デバイス番号を取得できません: %s", path)
+	}
+	return uint64(st.Dev), nil
+}
+
+func sameDevice(a, b string) (bool, error) {
+	da, err := deviceOf(a)
+	if err != nil {
+		return false, err
+	}
+	db, err := deviceOf(b)
+	if err != nil {
+		return false, err
+	}
+	return da == db, nil
+}
+
+// findMountPoint はpathを含むファイルシステムのマウントポイント(topdir)を、
+// デバイス番号が変わる直前のディレクトリとして探します。
+func findMountPoint(path string) (string, error) {
+	dir := filepath.Dir(path)
+	dev, err := deviceOf(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir, nil
+		}
+		parentDev, err := deviceOf(parent)
+		if err != nil || parentDev != dev {
+			return dir, nil
+		}
+		dir = parent
+	}
+}
+
+// uniqueTrashName はfilesDir内での名前衝突を避けるため、必要なら連番を挟みます。
+func uniqueTrashName(filesDir, base string) string {
+	name := base
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(filepath.Join(filesDir, name)); os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s.%d%s", stem, i, ext)
+	}
+}
+
+// renameOrCopy はos.Renameを試み、デバイスをまたぐ等で失敗した場合のみ
+// コピー後に元ファイルを削除します。
+func renameOrCopy(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
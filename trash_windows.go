@@ -0,0 +1,60 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// SHFileOperationW (shell32.dll) に渡すSHFILEOPSTRUCTW。
+type shFileOpStructW struct {
+	hwnd                  uintptr
+	wFunc                 uint32
+	pFrom                 *uint16
+	pTo                   *uint16
+	fFlags                uint16
+	fAnyOperationsAborted int32
+	hNameMappings         uintptr
+	lpszProgressTitle     *uint16
+}
+
+const (
+	foDelete          = 0x0003
+	fofAllowUndo      = 0x0040 // ごみ箱へ移動する(即時削除しない)
+	fofNoConfirmation = 0x0010
+	fofSilent         = 0x0004
+)
+
+var (
+	modShell32           = windows.NewLazySystemDLL("shell32.dll")
+	procSHFileOperationW = modShell32.NewProc("SHFileOperationW")
+)
+
+// nativeTrash はSHFileOperationWを FO_DELETE|FOF_ALLOWUNDO で呼び出し、
+// Windowsのごみ箱に移動します。
+func nativeTrash(path string) error {
+	// pFromは二重NUL終端された文字列である必要がある(複数パス列挙の終端マーカー)。
+	from, err := windows.UTF16FromString(path)
+	if err != nil {
+		return err
+	}
+	from = append(from, 0)
+
+	op := shFileOpStructW{
+		wFunc:  foDelete,
+		pFrom:  &from[0],
+		fFlags: fofAllowUndo | fofNoConfirmation | fofSilent,
+	}
+
+	ret, _, _ := procSHFileOperationW.Call(uintptr(unsafe.Pointer(&op)))
+	if ret != 0 {
+		return fmt.Errorf("SHFileOperationW に失敗しました: code=%d", ret)
+	}
+	if op.fAnyOperationsAborted != 0 {
+		return fmt.Errorf("SHFileOperationW: 操作が中断されました")
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/fsnotify/fsnotify"
+)
+
+// eventDebouncer はパスごとにファイルシステムイベントのバーストをまとめ、
+// 一定時間イベントが来なくなってから一度だけfnを実行します。
+type eventDebouncer struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+	quiet  time.Duration
+}
+
+func newEventDebouncer(quiet time.Duration) *eventDebouncer {
+	return &eventDebouncer{timers: make(map[string]*time.Timer), quiet: quiet}
+}
+
+// schedule はpathに対するfnの実行をデバウンスします。quiet時間内に再度呼ばれると
+// タイマーがリセットされ、直前の呼び出し分のfnは実行されません。
+func (d *eventDebouncer) schedule(path string, fn func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if t, ok := d.timers[path]; ok {
+		t.Reset(d.quiet)
+		return
+	}
+	d.timers[path] = time.AfterFunc(d.quiet, func() {
+		d.mu.Lock()
+		delete(d.timers, path)
+		d.mu.Unlock()
+		fn()
+	})
+}
+
+// watchDirRecursive はstart配下のディレクトリを再帰的に歩き、隠しディレクトリ(.gitなど)と
+// excludeのglobパターンに一致するディレクトリを除いて、それ以外をすべてwatcherに登録します。
+// globalRootは --max-depth の深さ計算とexcludeの相対パス計算の基準です。
+// .gitignoreそのものを解釈するわけではなく、--excludeに指定されたglobパターンのみを見る点に注意してください。
+func watchDirRecursive(watcher *fsnotify.Watcher, globalRoot, start string, maxDepth int, exclude []string) error {
+	return filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != globalRoot && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if path != globalRoot && !matchesWatchFilters(globalRoot, path, nil, exclude) {
+			return filepath.SkipDir
+		}
+		if maxDepth > 0 && watchDepth(globalRoot, path) > maxDepth {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("ディレクトリの監視登録に失敗: %s -> %w", path, err)
+		}
+		return nil
+	})
+}
+
+// watchDepth はglobalRootから見たpathの深さ(直下の子=1)を返します。
+func watchDepth(globalRoot, path string) int {
+	rel, err := filepath.Rel(globalRoot, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return len(strings.Split(filepath.ToSlash(rel), "/"))
+}
+
+// matchesWatchFilters は --include / --exclude のglobパターンを、監視ルートからの
+// 相対パスに対して評価します。excludeに一致すれば除外、includeが空なら常に対象、
+// 指定されていればいずれかに一致した場合のみ対象とします。
+func matchesWatchFilters(root, path string, include, exclude []string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	rel = filepath.ToSlash(rel)
+
+	for _, pat := range exclude {
+		if ok, _ := doublestar.Match(pat, rel); ok {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, pat := range include {
+		if ok, _ := doublestar.Match(pat, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForStableFile はpathのサイズとmtimeを interval 間隔でポーリングし、
+// samples回連続で変化がなければ書き込み完了とみなして nil を返します。
+// timeout に達しても安定しなかった場合はエラーを返します(呼び出し側は変換を続行するか判断できます)。
+func waitForStableFile(path string, interval time.Duration, samples int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	var lastSize int64 = -1
+	var lastMod time.Time
+	stableCount := 0
+
+	for {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("書き込み安定待機中にファイル情報の取得に失敗: %w", err)
+		}
+
+		if info.Size() == lastSize && info.ModTime().Equal(lastMod) {
+			stableCount++
+			if stableCount >= samples {
+				return nil
+			}
+		} else {
+			stableCount = 0
+			lastSize = info.Size()
+			lastMod = info.ModTime()
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s 待っても %s の書き込みが安定しませんでした", timeout, path)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestWatchDirRecursiveRegistersNestedDirs(t *testing.T) {
+	root := t.TempDir()
+	mustMkdirAll(t, filepath.Join(root, "a", "b"))
+	mustMkdirAll(t, filepath.Join(root, ".git")) // 隠しディレクトリは除外されるはず
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatalf("fsnotify.NewWatcher: %v", err)
+	}
+	defer watcher.Close()
+
+	if err := watchDirRecursive(watcher, root, root, 0, nil); err != nil {
+		t.Fatalf("watchDirRecursive: %v", err)
+	}
+
+	got := watcher.WatchList()
+	want := []string{root, filepath.Join(root, "a"), filepath.Join(root, "a", "b")}
+	assertSameSet(t, got, want)
+}
+
+func TestWatchDirRecursiveRespectsMaxDepth(t *testing.T) {
+	root := t.TempDir()
+	mustMkdirAll(t, filepath.Join(root, "a", "b", "c"))
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatalf("fsnotify.NewWatcher: %v", err)
+	}
+	defer watcher.Close()
+
+	if err := watchDirRecursive(watcher, root, root, 1, nil); err != nil {
+		t.Fatalf("watchDirRecursive: %v", err)
+	}
+
+	got := watcher.WatchList()
+	want := []string{root, filepath.Join(root, "a")}
+	assertSameSet(t, got, want)
+}
+
+func TestWatchDirRecursiveRespectsExclude(t *testing.T) {
+	root := t.TempDir()
+	mustMkdirAll(t, filepath.Join(root, "a"))
+	mustMkdirAll(t, filepath.Join(root, "node_modules", "pkg"))
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatalf("fsnotify.NewWatcher: %v", err)
+	}
+	defer watcher.Close()
+
+	if err := watchDirRecursive(watcher, root, root, 0, []string{"node_modules/**"}); err != nil {
+		t.Fatalf("watchDirRecursive: %v", err)
+	}
+
+	got := watcher.WatchList()
+	want := []string{root, filepath.Join(root, "a")}
+	assertSameSet(t, got, want)
+}
+
+func TestWatchDirRecursivePicksUpNewlyCreatedSubdir(t *testing.T) {
+	root := t.TempDir()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatalf("fsnotify.NewWatcher: %v", err)
+	}
+	defer watcher.Close()
+
+	if err := watchDirRecursive(watcher, root, root, 0, nil); err != nil {
+		t.Fatalf("watchDirRecursive: %v", err)
+	}
+
+	// ルート直下に新しいディレクトリを作り、さらにその中にファイルを作る。
+	// runWatchMode のイベントループが行うのと同じ手順(再帰登録)を手動で再現する。
+	newDir := filepath.Join(root, "newdir")
+	mustMkdirAll(t, newDir)
+	if err := watchDirRecursive(watcher, root, newDir, 0, nil); err != nil {
+		t.Fatalf("watchDirRecursive on new subdir: %v", err)
+	}
+
+	nestedFile := filepath.Join(newDir, "clip.mp4")
+	if err := os.WriteFile(nestedFile, []byte("x"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-watcher.Events:
+			if event.Name == nestedFile {
+				return
+			}
+			// ルートディレクトリ自体のCreateイベントなど無関係なものは読み飛ばす。
+		case err := <-watcher.Errors:
+			t.Fatalf("watcher error: %v", err)
+		case <-deadline:
+			t.Fatal("timed out waiting for event on file created in newly watched subdir")
+		}
+	}
+}
+
+func TestWatchDirRecursiveRemovesDeletedSubdirFromWatch(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "gone")
+	mustMkdirAll(t, sub)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatalf("fsnotify.NewWatcher: %v", err)
+	}
+	defer watcher.Close()
+
+	if err := watchDirRecursive(watcher, root, root, 0, nil); err != nil {
+		t.Fatalf("watchDirRecursive: %v", err)
+	}
+
+	if err := os.RemoveAll(sub); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+
+	// runWatchMode と同様、Remove/Renameイベントを受けたら監視解除を試みる。
+	// エラーが出ても(既に消えている等)無視してよい。
+	_ = watcher.Remove(sub)
+
+	got := watcher.WatchList()
+	for _, p := range got {
+		if p == sub {
+			t.Fatalf("expected %q to be removed from watch list, still present: %v", sub, got)
+		}
+	}
+}
+
+func TestMatchesWatchFilters(t *testing.T) {
+	root := "/rec"
+	cases := []struct {
+		name    string
+		path    string
+		include []string
+		exclude []string
+		want    bool
+	}{
+		{"no filters matches everything", "/rec/a/b.mp4", nil, nil, true},
+		{"include matches", "/rec/clips/a.mp4", []string{"clips/**"}, nil, true},
+		{"include does not match", "/rec/other/a.mp4", []string{"clips/**"}, nil, false},
+		{"exclude wins over include", "/rec/clips/tmp/a.mp4", []string{"clips/**"}, []string{"clips/tmp/**"}, false},
+		{"exclude only", "/rec/a.mp4", nil, []string{"*.mp4"}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := matchesWatchFilters(root, c.path, c.include, c.exclude)
+			if got != c.want {
+				t.Errorf("matchesWatchFilters(%q) = %v, want %v", c.path, got, c.want)
+			}
+		})
+	}
+}
+
+func mustMkdirAll(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatalf("MkdirAll(%q): %v", path, err)
+	}
+}
+
+func assertSameSet(t *testing.T, got, want []string) {
+	t.Helper()
+	gotSorted := append([]string(nil), got...)
+	wantSorted := append([]string(nil), want...)
+	sort.Strings(gotSorted)
+	sort.Strings(wantSorted)
+	if len(gotSorted) != len(wantSorted) {
+		t.Fatalf("got %v, want %v", gotSorted, wantSorted)
+	}
+	for i := range gotSorted {
+		if gotSorted[i] != wantSorted[i] {
+			t.Fatalf("got %v, want %v", gotSorted, wantSorted)
+		}
+	}
+}